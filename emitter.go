@@ -0,0 +1,91 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	metrics "github.com/rcrowley/go-metrics"
+	"io"
+	"time"
+)
+
+// MetricsEmitter is the extension point consumerMetrics reports through.
+// Implementations receive a JSON-encoded snapshot of the metrics registry on
+// every tick of ReportingInterval and decide what to do with it.
+type MetricsEmitter interface {
+	// Emit is called once per ReportingInterval with a JSON-encoded
+	// snapshot of the consumer's metrics registry.
+	Emit(bytes []byte)
+
+	// ReportingInterval controls how often Emit is called.
+	ReportingInterval() time.Duration
+}
+
+// RegistryAttacher is implemented by MetricsEmitters that report straight
+// from a metrics.Registry on their own schedule (InfluxDB, Graphite) instead
+// of receiving pre-serialized snapshots through Emit. consumerMetrics checks
+// for this and, when present, skips its own JSON-over-Emit ticker so the
+// registry isn't reported twice. The returned io.Closer stops the reporting
+// loop AttachRegistry started for that registry; consumerMetrics.Close()
+// calls it so closing a consumer doesn't leak a ticker and goroutine that
+// keeps polling (and, for network-backed emitters, writing to) an
+// unregistered registry forever.
+type RegistryAttacher interface {
+	AttachRegistry(registry metrics.Registry) io.Closer
+}
+
+// LabelAttacher is implemented by MetricsEmitters that render labeled
+// output (e.g. Prometheus) and want the name->label mapping consumerMetrics
+// builds as it registers each metric, rather than re-deriving labels by
+// parsing the flattened, dash-joined metric name.
+type LabelAttacher interface {
+	AttachLabels(labels MetricLabels)
+}
+
+// tickerCloser stops a ticker-driven reporting loop started by a
+// RegistryAttacher implementation. Close is safe to call once.
+type tickerCloser struct {
+	ticker *time.Ticker
+	done   chan struct{}
+}
+
+func newTickerCloser(ticker *time.Ticker, done chan struct{}) *tickerCloser {
+	return &tickerCloser{ticker: ticker, done: done}
+}
+
+func (this *tickerCloser) Close() error {
+	this.ticker.Stop()
+	close(this.done)
+	return nil
+}
+
+// EmptyMetricsEmitter is the default MetricsEmitter: it discards everything
+// it is given. It exists so consumers that never configure EmitterMetrics
+// still get a harmless, always-valid emitter.
+type EmptyMetricsEmitter struct {
+	reportingInterval time.Duration
+}
+
+// NewEmptyMetricsEmitter creates a MetricsEmitter that does nothing with the
+// metrics it is handed.
+func NewEmptyMetricsEmitter() MetricsEmitter {
+	return &EmptyMetricsEmitter{reportingInterval: 5 * time.Second}
+}
+
+func (this *EmptyMetricsEmitter) Emit(bytes []byte) {}
+
+func (this *EmptyMetricsEmitter) ReportingInterval() time.Duration {
+	return this.reportingInterval
+}
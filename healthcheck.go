@@ -0,0 +1,179 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"fmt"
+	metrics "github.com/rcrowley/go-metrics"
+	"time"
+)
+
+// DefaultHealthCheckInterval is how often a consumerMetrics with at least
+// one registered health check re-evaluates it.
+const DefaultHealthCheckInterval = 10 * time.Second
+
+// HealthCheckConfig bounds the thresholds a registered health check
+// evaluates the consumer's metrics against. A zero field is not checked.
+type HealthCheckConfig struct {
+	// MaxIdle fails the check once the highest fetch idle time observed by
+	// fetchersIdleTimer exceeds this.
+	MaxIdle time.Duration
+
+	// MaxLag fails the check once the offset lag gauge - the consumer-wide
+	// one, or the worst per-partition one when per-partition metrics are
+	// enabled - exceeds this many messages.
+	MaxLag int64
+
+	// MaxPendingTasks fails the check once pendingWMsTasksCounter exceeds
+	// this many queued worker manager tasks.
+	MaxPendingTasks int64
+
+	// MaxBatchDuration fails the check once the highest worker manager
+	// batch duration observed by wmsBatchDurationTimer exceeds this.
+	MaxBatchDuration time.Duration
+}
+
+// HealthResult is the outcome of evaluating a single registered health
+// check. Err is a string rather than an error so it survives JSON encoding
+// over /healthz - the error interface has no exported fields, so encoding
+// it directly marshals to "{}" and silently drops the failure message.
+type HealthResult struct {
+	Consumer string
+	Name     string
+	Healthy  bool
+	Err      string
+}
+
+// HealthEmitter is implemented by MetricsEmitters that want registered
+// health check results delivered on the same cadence as metric snapshots,
+// e.g. to serve them over HTTP or forward them to an alerting system.
+type HealthEmitter interface {
+	EmitHealth(results []HealthResult)
+}
+
+type registeredHealthCheck struct {
+	name   string
+	config HealthCheckConfig
+	check  metrics.Healthcheck
+}
+
+// RegisterHealthCheck registers a named check, evaluated against this
+// consumerMetrics' fetchersIdleTimer, offset lag gauges,
+// pendingWMsTasksCounter and worker manager batch duration timer every
+// DefaultHealthCheckInterval. The first call to RegisterHealthCheck starts
+// the evaluation loop; results are handed to EmitterMetrics on each tick if
+// it implements HealthEmitter.
+func (this *consumerMetrics) RegisterHealthCheck(name string, config HealthCheckConfig) {
+	this.healthLock.Lock()
+	defer this.healthLock.Unlock()
+
+	registered := &registeredHealthCheck{name: name, config: config}
+	registered.check = metrics.NewHealthcheck(func(h metrics.Healthcheck) {
+		this.evaluateHealthCheck(h, registered.config)
+	})
+	this.healthChecks = append(this.healthChecks, registered)
+
+	if this.healthTicker == nil {
+		this.healthTicker = time.NewTicker(DefaultHealthCheckInterval)
+		this.healthDone = make(chan struct{})
+		go this.runHealthChecks()
+	}
+}
+
+func (this *consumerMetrics) runHealthChecks() {
+	for {
+		select {
+		case <-this.healthTicker.C:
+			results := this.Healthcheck()
+			if emitter, ok := EmitterMetrics.(HealthEmitter); ok {
+				emitter.EmitHealth(results)
+			}
+		case <-this.healthDone:
+			return
+		}
+	}
+}
+
+// Healthcheck runs every registered health check and returns its latest
+// results.
+func (this *consumerMetrics) Healthcheck() []HealthResult {
+	this.healthLock.Lock()
+	checks := make([]*registeredHealthCheck, len(this.healthChecks))
+	copy(checks, this.healthChecks)
+	this.healthLock.Unlock()
+
+	results := make([]HealthResult, 0, len(checks))
+	for _, registered := range checks {
+		registered.check.Check()
+		var errMessage string
+		if err := registered.check.Error(); err != nil {
+			errMessage = err.Error()
+		}
+		results = append(results, HealthResult{
+			Consumer: this.consumerName,
+			Name:     registered.name,
+			Healthy:  registered.check.Error() == nil,
+			Err:      errMessage,
+		})
+	}
+
+	return results
+}
+
+func (this *consumerMetrics) evaluateHealthCheck(h metrics.Healthcheck, config HealthCheckConfig) {
+	if config.MaxIdle > 0 {
+		if idle := time.Duration(this.fetchersIdleTimer.Max()); idle > config.MaxIdle {
+			h.Unhealthy(fmt.Errorf("fetchers idle for %s, exceeds max idle %s", idle, config.MaxIdle))
+			return
+		}
+	}
+
+	if config.MaxLag > 0 {
+		if lag := this.maxOffsetLag(); lag > config.MaxLag {
+			h.Unhealthy(fmt.Errorf("offset lag %d exceeds max lag %d", lag, config.MaxLag))
+			return
+		}
+	}
+
+	if config.MaxPendingTasks > 0 {
+		if pending := this.pendingWMsTasksCounter.Count(); pending > config.MaxPendingTasks {
+			h.Unhealthy(fmt.Errorf("pending worker manager tasks %d exceeds max %d", pending, config.MaxPendingTasks))
+			return
+		}
+	}
+
+	if config.MaxBatchDuration > 0 {
+		if duration := time.Duration(this.wmsBatchDurationTimer.Max()); duration > config.MaxBatchDuration {
+			h.Unhealthy(fmt.Errorf("worker manager batch duration %s exceeds max %s", duration, config.MaxBatchDuration))
+			return
+		}
+	}
+
+	h.Healthy()
+}
+
+// maxOffsetLag returns the highest offset lag currently observed, across
+// the consumer-wide gauge and, when per-partition metrics are enabled,
+// every tracked partition.
+func (this *consumerMetrics) maxOffsetLag() int64 {
+	max := this.offsetLagGauge.Value()
+
+	if partitionMax := this.partitions.maxOffsetLag(); partitionMax > max {
+		max = partitionMax
+	}
+
+	return max
+}
@@ -0,0 +1,57 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestHealthcheckReportsFailureMessage reproduces the /healthz bug where a
+// failing check's message was silently dropped: HealthResult.Err used to be
+// an error, which has no exported fields and marshals to "{}".
+func TestHealthcheckReportsFailureMessage(t *testing.T) {
+	kafkaMetrics := newConsumerMetrics("myConsumer", "", nil, nil)
+	defer kafkaMetrics.Close()
+
+	kafkaMetrics.fetchersIdleTimer.Update(2 * time.Second)
+	kafkaMetrics.RegisterHealthCheck("idle", HealthCheckConfig{MaxIdle: time.Second})
+
+	results := kafkaMetrics.Healthcheck()
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+	if results[0].Healthy {
+		t.Fatal("expected the check to be unhealthy")
+	}
+	if results[0].Err == "" {
+		t.Fatal("expected a non-empty failure message")
+	}
+
+	encoded, err := json.Marshal(results[0])
+	if err != nil {
+		t.Fatalf("failed to marshal result: %s", err)
+	}
+
+	var decoded HealthResult
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal result: %s", err)
+	}
+	if decoded.Err != results[0].Err {
+		t.Fatalf("failure message did not round-trip through JSON: got %q, want %q", decoded.Err, results[0].Err)
+	}
+}
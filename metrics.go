@@ -20,63 +20,164 @@ import (
 	"bytes"
 	"fmt"
 	metrics "github.com/rcrowley/go-metrics"
+	"io"
+	"strings"
+	"sync"
 	"time"
 )
 
 var EmitterMetrics MetricsEmitter = NewEmptyMetricsEmitter()
 
 type consumerMetrics struct {
-	registry metrics.Registry
-	ticker   *time.Ticker
+	consumerName   string
+	prefix         string
+	registry       metrics.Registry
+	sharedRegistry bool
+	ticker         *time.Ticker
+	registryCloser io.Closer
+
+	config     *MetricsConfig
+	partitions *partitionMetricsRegistry
+	labels     *metricLabelRegistry
+	timers     *resettingTimerRegistry
 
 	numFetchRoutinesCounter metrics.Counter
 	fetchersIdleTimer       metrics.Timer
-	fetchDurationTimer      metrics.Timer
+	fetchDurationTimer      DurationTimer
+
+	numWorkerManagersGauge  metrics.Gauge
+	activeWorkersCounter    metrics.Counter
+	pendingWMsTasksCounter  metrics.Counter
+	wmsBatchDurationTimer   DurationTimer
+	wmsIdleTimer            metrics.Timer
+	messagesConsumedCounter metrics.Counter
+	bytesConsumedCounter    metrics.Counter
+	offsetLagGauge          metrics.Gauge
+	commitDurationTimer     DurationTimer
 
-	numWorkerManagersGauge metrics.Gauge
-	activeWorkersCounter   metrics.Counter
-	pendingWMsTasksCounter metrics.Counter
-	wmsBatchDurationTimer  metrics.Timer
-	wmsIdleTimer           metrics.Timer
+	healthLock   sync.Mutex
+	healthChecks []*registeredHealthCheck
+	healthTicker *time.Ticker
+	healthDone   chan struct{}
 }
 
-func newConsumerMetrics(consumerName string) *consumerMetrics {
+// newConsumerMetrics builds the metrics for a single consumer. If registry
+// is nil, a fresh metrics.NewRegistry() is allocated and consumerMetrics
+// drives its own reporting through EmitterMetrics, same as before. If
+// registry is non-nil, it is used as-is - this is SharedEmitter mode, for
+// callers that want everything registered into one shared
+// metrics.Registry (e.g. metrics.DefaultRegistry, or a registry an
+// application-level reporter already drives) instead of a dedicated one per
+// consumer. In that mode consumerMetrics never starts its own ticker/emit
+// goroutine, since the caller owns reporting and a second loop would just
+// report the same data twice. prefix, if non-empty, is prepended to every
+// registered metric name with a dot, so it composes with a
+// statsd/graphite-friendly dotted naming scheme.
+func newConsumerMetrics(consumerName string, prefix string, registry metrics.Registry, config *MetricsConfig) *consumerMetrics {
+	if config == nil {
+		config = NewMetricsConfig()
+	}
+
+	sharedRegistry := registry != nil
+	if registry == nil {
+		registry = metrics.NewRegistry()
+	}
+
 	kafkaMetrics := &consumerMetrics{
-		registry: metrics.NewRegistry(),
+		consumerName:   consumerName,
+		prefix:         prefix,
+		registry:       registry,
+		sharedRegistry: sharedRegistry,
+		config:         config,
+		labels:         newMetricLabelRegistry(),
+		timers:         newResettingTimerRegistry(),
 	}
+	kafkaMetrics.partitions = newPartitionMetricsRegistry(consumerName, prefix, kafkaMetrics.registry, config, kafkaMetrics.labels, kafkaMetrics.timers)
 
-	kafkaMetrics.fetchersIdleTimer = metrics.NewRegisteredTimer(fmt.Sprintf("FetchersIdleTime-%s", consumerName), kafkaMetrics.registry)
-	kafkaMetrics.fetchDurationTimer = metrics.NewRegisteredTimer(fmt.Sprintf("FetchDuration-%s", consumerName), kafkaMetrics.registry)
+	useResetting := config.UseResettingTimers
 
-	kafkaMetrics.numWorkerManagersGauge = metrics.NewRegisteredGauge(fmt.Sprintf("NumWorkerManagers-%s", consumerName), kafkaMetrics.registry)
-	kafkaMetrics.activeWorkersCounter = metrics.NewRegisteredCounter(fmt.Sprintf("WMsActiveWorkers-%s", consumerName), kafkaMetrics.registry)
-	kafkaMetrics.pendingWMsTasksCounter = metrics.NewRegisteredCounter(fmt.Sprintf("WMsPendingTasks-%s", consumerName), kafkaMetrics.registry)
-	kafkaMetrics.wmsBatchDurationTimer = metrics.NewRegisteredTimer(fmt.Sprintf("WMsBatchDuration-%s", consumerName), kafkaMetrics.registry)
-	kafkaMetrics.wmsIdleTimer = metrics.NewRegisteredTimer(fmt.Sprintf("WMsIdleTime-%s", consumerName), kafkaMetrics.registry)
+	kafkaMetrics.fetchersIdleTimer = metrics.NewRegisteredTimer(kafkaMetrics.metricName("FetchersIdleTime"), kafkaMetrics.registry)
+	kafkaMetrics.fetchDurationTimer = newDurationTimer(useResetting, kafkaMetrics.metricName("FetchDuration"), kafkaMetrics.registry, kafkaMetrics.timers)
 
-	kafkaMetrics.ticker = time.NewTicker(EmitterMetrics.ReportingInterval())
+	kafkaMetrics.numWorkerManagersGauge = metrics.NewRegisteredGauge(kafkaMetrics.metricName("NumWorkerManagers"), kafkaMetrics.registry)
+	kafkaMetrics.activeWorkersCounter = metrics.NewRegisteredCounter(kafkaMetrics.metricName("WMsActiveWorkers"), kafkaMetrics.registry)
+	kafkaMetrics.pendingWMsTasksCounter = metrics.NewRegisteredCounter(kafkaMetrics.metricName("WMsPendingTasks"), kafkaMetrics.registry)
+	kafkaMetrics.wmsBatchDurationTimer = newDurationTimer(useResetting, kafkaMetrics.metricName("WMsBatchDuration"), kafkaMetrics.registry, kafkaMetrics.timers)
+	kafkaMetrics.wmsIdleTimer = metrics.NewRegisteredTimer(kafkaMetrics.metricName("WMsIdleTime"), kafkaMetrics.registry)
+	kafkaMetrics.messagesConsumedCounter = metrics.NewRegisteredCounter(kafkaMetrics.metricName("MessagesConsumed"), kafkaMetrics.registry)
+	kafkaMetrics.bytesConsumedCounter = metrics.NewRegisteredCounter(kafkaMetrics.metricName("BytesConsumed"), kafkaMetrics.registry)
+	kafkaMetrics.offsetLagGauge = metrics.NewRegisteredGauge(kafkaMetrics.metricName("OffsetLag"), kafkaMetrics.registry)
+	kafkaMetrics.commitDurationTimer = newDurationTimer(useResetting, kafkaMetrics.metricName("CommitDuration"), kafkaMetrics.registry, kafkaMetrics.timers)
 
-	go func() {
-		for _ = range kafkaMetrics.ticker.C {
-			buffer := &bytes.Buffer{}
-			writer := bufio.NewWriter(buffer)
-			metrics.WriteJSONOnce(kafkaMetrics.registry, writer)
-			if err := writer.Flush(); err != nil {
-				panic(err)
-			}
+	for _, base := range consumerMetricBases {
+		kafkaMetrics.labels.set(kafkaMetrics.metricName(base), base, kafkaMetrics.consumerLabels())
+	}
 
-			EmitterMetrics.Emit(buffer.Bytes())
-		}
-	}()
+	if attacher, ok := EmitterMetrics.(LabelAttacher); ok {
+		attacher.AttachLabels(kafkaMetrics.labels)
+	}
+	if attacher, ok := EmitterMetrics.(ResettingTimerAttacher); ok {
+		attacher.AttachResettingTimers(kafkaMetrics.timers)
+	}
+
+	if sharedRegistry {
+		// SharedEmitter mode: the caller owns this registry and drives its
+		// own reporting, so consumerMetrics stays out of it entirely.
+	} else if attacher, ok := EmitterMetrics.(RegistryAttacher); ok {
+		// Emitters like InfluxDB/Graphite read the registry directly on
+		// their own schedule, so the JSON-over-Emit ticker below would
+		// just report the same data a second time.
+		kafkaMetrics.registryCloser = attacher.AttachRegistry(kafkaMetrics.registry)
+	} else {
+		kafkaMetrics.ticker = time.NewTicker(EmitterMetrics.ReportingInterval())
+
+		go func() {
+			for _ = range kafkaMetrics.ticker.C {
+				buffer := &bytes.Buffer{}
+				writer := bufio.NewWriter(buffer)
+				metrics.WriteJSONOnce(kafkaMetrics.registry, writer)
+				if err := writer.Flush(); err != nil {
+					panic(err)
+				}
+
+				EmitterMetrics.Emit(buffer.Bytes())
+			}
+		}()
+	}
 
 	return kafkaMetrics
 }
 
+// metricName formats base into this consumer's registered metric name,
+// preserving the existing "Base-consumerName" convention and prepending
+// this.prefix with a dot when one is configured.
+func (this *consumerMetrics) metricName(base string) string {
+	name := fmt.Sprintf("%s-%s", base, this.consumerName)
+	if this.prefix != "" {
+		name = this.prefix + "." + name
+	}
+	return name
+}
+
+// consumerLabels returns the label set consumer-wide (non-per-partition)
+// metrics are registered with: consumer, and client_id when configured.
+func (this *consumerMetrics) consumerLabels() map[string]string {
+	labels := map[string]string{"consumer": this.consumerName}
+	if this.config != nil && this.config.ClientID != "" {
+		labels["client_id"] = this.config.ClientID
+	}
+	return labels
+}
+
 func (this *consumerMetrics) FetchersIdleTimer() metrics.Timer {
 	return this.fetchersIdleTimer
 }
 
-func (this *consumerMetrics) FetchDurationTimer() metrics.Timer {
+// FetchDurationTimer returns the consumer-wide fetch duration timer. Its
+// concrete type depends on MetricsConfig.UseResettingTimers: a metrics.Timer
+// by default, or a *ResettingTimer when resetting timers are enabled, which
+// only satisfies DurationTimer, not the richer metrics.Timer interface.
+func (this *consumerMetrics) FetchDurationTimer() DurationTimer {
 	return this.fetchDurationTimer
 }
 
@@ -88,7 +189,10 @@ func (this *consumerMetrics) WMsIdleTimer() metrics.Timer {
 	return this.wmsIdleTimer
 }
 
-func (this *consumerMetrics) WMsBatchDurationTimer() metrics.Timer {
+// WMsBatchDurationTimer returns the consumer-wide worker manager batch
+// duration timer. See FetchDurationTimer for why its type is DurationTimer
+// rather than metrics.Timer.
+func (this *consumerMetrics) WMsBatchDurationTimer() DurationTimer {
 	return this.wmsBatchDurationTimer
 }
 
@@ -100,9 +204,77 @@ func (this *consumerMetrics) ActiveWorkersCounter() metrics.Counter {
 	return this.activeWorkersCounter
 }
 
+// RecordFetch updates the consumer-wide fetch duration timer, plus the
+// per-TopicAndPartition fetch duration timer and consumed message/byte
+// counters when per-partition metrics are enabled for tp's topic.
+func (this *consumerMetrics) RecordFetch(tp TopicAndPartition, duration time.Duration, numMessages int64, numBytes int64) {
+	this.fetchDurationTimer.Update(duration)
+	this.messagesConsumedCounter.Inc(numMessages)
+	this.bytesConsumedCounter.Inc(numBytes)
+
+	if partition := this.partitions.getOrCreate(tp); partition != nil {
+		partition.fetchDurationTimer.Update(duration)
+		partition.messagesConsumedCounter.Inc(numMessages)
+		partition.bytesConsumedCounter.Inc(numBytes)
+	}
+}
+
+// RecordWMsBatch updates the consumer-wide and, if enabled, per-partition
+// worker manager batch duration timer.
+func (this *consumerMetrics) RecordWMsBatch(tp TopicAndPartition, duration time.Duration) {
+	this.wmsBatchDurationTimer.Update(duration)
+
+	if partition := this.partitions.getOrCreate(tp); partition != nil {
+		partition.wmsBatchDurationTimer.Update(duration)
+	}
+}
+
+// RecordActiveWorkers adjusts the consumer-wide and, if enabled,
+// per-partition active worker counters by delta.
+func (this *consumerMetrics) RecordActiveWorkers(tp TopicAndPartition, delta int64) {
+	this.activeWorkersCounter.Inc(delta)
+
+	if partition := this.partitions.getOrCreate(tp); partition != nil {
+		partition.activeWorkersCounter.Inc(delta)
+	}
+}
+
+// RecordPendingTasks adjusts the consumer-wide and, if enabled,
+// per-partition pending worker manager task counters by delta.
+func (this *consumerMetrics) RecordPendingTasks(tp TopicAndPartition, delta int64) {
+	this.pendingWMsTasksCounter.Inc(delta)
+
+	if partition := this.partitions.getOrCreate(tp); partition != nil {
+		partition.pendingWMsTasksCounter.Inc(delta)
+	}
+}
+
+// RecordOffsetLag updates the consumer-wide and, if enabled, per-partition
+// offset lag gauge to lag.
+func (this *consumerMetrics) RecordOffsetLag(tp TopicAndPartition, lag int64) {
+	this.offsetLagGauge.Update(lag)
+
+	if partition := this.partitions.getOrCreate(tp); partition != nil {
+		partition.offsetLagGauge.Update(lag)
+	}
+}
+
+// RecordCommit updates the consumer-wide and, if enabled, per-partition
+// offset commit duration timer.
+func (this *consumerMetrics) RecordCommit(tp TopicAndPartition, duration time.Duration) {
+	this.commitDurationTimer.Update(duration)
+
+	if partition := this.partitions.getOrCreate(tp); partition != nil {
+		partition.commitDurationTimer.Update(duration)
+	}
+}
+
 func (this *consumerMetrics) Stats() map[string]map[string]float64 {
 	metricsMap := make(map[string]map[string]float64)
 	this.registry.Each(func(name string, metric interface{}) {
+		if !this.ownsMetric(name) {
+			return
+		}
 		metricsMap[name] = make(map[string]float64)
 		switch entry := metric.(type) {
 		case metrics.Counter:
@@ -151,7 +323,85 @@ func (this *consumerMetrics) Stats() map[string]map[string]float64 {
 	return metricsMap
 }
 
+// ownsMetric reports whether name is one of this consumer's own registered
+// metrics. In SharedEmitter mode this.registry can hold other consumers'
+// (or the caller's own) series too, so Stats() uses this to stay scoped to
+// the receiver rather than dumping the whole shared registry.
+func (this *consumerMetrics) ownsMetric(name string) bool {
+	for _, base := range consumerMetricBases {
+		if name == this.metricName(base) {
+			return true
+		}
+	}
+
+	infix := "-" + this.consumerName + "-"
+	for _, base := range partitionMetricBases {
+		partitionBase := base
+		if this.prefix != "" {
+			partitionBase = this.prefix + "." + base
+		}
+		if strings.HasPrefix(name, partitionBase+infix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// consumerMetricBases lists the consumer-wide (non-per-partition) metric
+// names Close must unregister individually in SharedEmitter mode, where
+// wiping the whole registry would also take out every other consumer's
+// metrics sharing it.
+var consumerMetricBases = []string{
+	"FetchersIdleTime", "FetchDuration",
+	"NumWorkerManagers", "WMsActiveWorkers", "WMsPendingTasks", "WMsBatchDuration", "WMsIdleTime",
+	"MessagesConsumed", "BytesConsumed", "OffsetLag", "CommitDuration",
+}
+
 func (this *consumerMetrics) Close() {
-	this.ticker.Stop()
-	this.registry.UnregisterAll()
+	if this.ticker != nil {
+		this.ticker.Stop()
+	}
+	if this.healthTicker != nil {
+		this.healthTicker.Stop()
+		close(this.healthDone)
+	}
+	if this.registryCloser != nil {
+		this.registryCloser.Close()
+	}
+
+	// A closed consumer never calls Emit again, so PrometheusEmitter's
+	// per-consumer pruning in Emit would never retire its series; drop them
+	// from the served snapshot here instead.
+	if emitter, ok := EmitterMetrics.(*PrometheusEmitter); ok {
+		emitter.lock.Lock()
+		for name := range emitter.snapshot {
+			if this.ownsMetric(name) {
+				delete(emitter.snapshot, name)
+			}
+		}
+		emitter.lock.Unlock()
+	}
+
+	// Unset this consumer's labels and resetting timers (and, transitively,
+	// its partitions') regardless of sharedRegistry: attached emitters keep
+	// reading both through the MetricLabels and ResettingTimerSource they
+	// were handed, and a resetting timer left in this.timers would keep
+	// being flushed by Graphite/InfluxDB forever.
+	for _, base := range consumerMetricBases {
+		name := this.metricName(base)
+		this.labels.unset(name)
+		this.timers.unset(name)
+	}
+	this.partitions.unregisterAll()
+
+	if this.sharedRegistry {
+		// A shared registry may hold other consumers' or the caller's own
+		// metrics - only unregister this consumer's own series.
+		for _, base := range consumerMetricBases {
+			this.registry.Unregister(this.metricName(base))
+		}
+	} else {
+		this.registry.UnregisterAll()
+	}
 }
@@ -0,0 +1,165 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"bufio"
+	"fmt"
+	metrics "github.com/rcrowley/go-metrics"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+var graphitePercentiles = []float64{0.5, 0.75, 0.95, 0.99}
+
+// GraphiteEmitter is a MetricsEmitter that background-flushes a metrics
+// registry straight to a Graphite carbon endpoint in plaintext protocol,
+// instead of relying on the default push-JSON-through-Emit model.
+type GraphiteEmitter struct {
+	addr     string
+	prefix   string
+	interval time.Duration
+
+	lock            sync.RWMutex
+	resettingTimers []ResettingTimerSource
+}
+
+// NewGraphiteEmitter creates a GraphiteEmitter that, once attached to a
+// registry, writes a plaintext protocol batch to addr every interval with
+// every metric name prepended by prefix.
+func NewGraphiteEmitter(addr string, prefix string, interval time.Duration) *GraphiteEmitter {
+	return &GraphiteEmitter{
+		addr:     addr,
+		prefix:   prefix,
+		interval: interval,
+	}
+}
+
+// AttachRegistry starts a goroutine that walks registry every interval and
+// writes its contents to the configured Graphite endpoint. A failed write is
+// logged and the loop continues on the next tick rather than killing the
+// consumer. The returned io.Closer stops the goroutine; the owning
+// consumerMetrics calls it from Close() so the loop doesn't outlive the
+// registry it reports from.
+func (this *GraphiteEmitter) AttachRegistry(registry metrics.Registry) io.Closer {
+	ticker := time.NewTicker(this.interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := this.flush(registry); err != nil {
+					log.Printf("[GraphiteEmitter] failed to write metrics to %s: %s", this.addr, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return newTickerCloser(ticker, done)
+}
+
+// AttachResettingTimers wires in a consumer's resetting timers so flush can
+// report them alongside the registry's own metrics. A GraphiteEmitter can be
+// shared by several consumers, so each call appends rather than replacing.
+func (this *GraphiteEmitter) AttachResettingTimers(source ResettingTimerSource) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.resettingTimers = append(this.resettingTimers, source)
+}
+
+func (this *GraphiteEmitter) flush(registry metrics.Registry) error {
+	conn, err := net.DialTimeout("tcp", this.addr, this.interval)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	now := time.Now().Unix()
+	writer := bufio.NewWriter(conn)
+
+	registry.Each(func(name string, i interface{}) {
+		switch metric := i.(type) {
+		case metrics.Counter:
+			fmt.Fprintf(writer, "%s.%s.count %d %d\n", this.prefix, name, metric.Count(), now)
+		case metrics.Gauge:
+			fmt.Fprintf(writer, "%s.%s.value %d %d\n", this.prefix, name, metric.Value(), now)
+		case metrics.GaugeFloat64:
+			fmt.Fprintf(writer, "%s.%s.value %f %d\n", this.prefix, name, metric.Value(), now)
+		case metrics.Meter:
+			m := metric.Snapshot()
+			fmt.Fprintf(writer, "%s.%s.count %d %d\n", this.prefix, name, m.Count(), now)
+			fmt.Fprintf(writer, "%s.%s.rate1 %f %d\n", this.prefix, name, m.Rate1(), now)
+			fmt.Fprintf(writer, "%s.%s.rate5 %f %d\n", this.prefix, name, m.Rate5(), now)
+			fmt.Fprintf(writer, "%s.%s.rate15 %f %d\n", this.prefix, name, m.Rate15(), now)
+		case metrics.Histogram:
+			h := metric.Snapshot()
+			ps := h.Percentiles(graphitePercentiles)
+			fmt.Fprintf(writer, "%s.%s.count %d %d\n", this.prefix, name, h.Count(), now)
+			writePercentiles(writer, this.prefix, name, ps, now)
+		case metrics.Timer:
+			t := metric.Snapshot()
+			ps := t.Percentiles(graphitePercentiles)
+			fmt.Fprintf(writer, "%s.%s.count %d %d\n", this.prefix, name, t.Count(), now)
+			writePercentiles(writer, this.prefix, name, ps, now)
+		}
+	})
+
+	this.flushResettingTimers(writer, now)
+
+	return writer.Flush()
+}
+
+// flushResettingTimers writes every attached consumer's ResettingTimers,
+// since they live in a side-channel registry.Each never sees - see
+// ResettingTimer's doc comment for why.
+func (this *GraphiteEmitter) flushResettingTimers(writer *bufio.Writer, now int64) {
+	this.lock.RLock()
+	sources := this.resettingTimers
+	this.lock.RUnlock()
+
+	for _, source := range sources {
+		source.Each(func(name string, timer *ResettingTimer) {
+			s := timer.Snapshot(graphitePercentiles...)
+			fmt.Fprintf(writer, "%s.%s.count %d %d\n", this.prefix, name, s.Count, now)
+			fmt.Fprintf(writer, "%s.%s.p50 %d %d\n", this.prefix, name, s.Percentiles[0.5], now)
+			fmt.Fprintf(writer, "%s.%s.p75 %d %d\n", this.prefix, name, s.Percentiles[0.75], now)
+			fmt.Fprintf(writer, "%s.%s.p95 %d %d\n", this.prefix, name, s.Percentiles[0.95], now)
+			fmt.Fprintf(writer, "%s.%s.p99 %d %d\n", this.prefix, name, s.Percentiles[0.99], now)
+		})
+	}
+}
+
+func writePercentiles(writer *bufio.Writer, prefix string, name string, ps []float64, now int64) {
+	fmt.Fprintf(writer, "%s.%s.p50 %f %d\n", prefix, name, ps[0], now)
+	fmt.Fprintf(writer, "%s.%s.p75 %f %d\n", prefix, name, ps[1], now)
+	fmt.Fprintf(writer, "%s.%s.p95 %f %d\n", prefix, name, ps[2], now)
+	fmt.Fprintf(writer, "%s.%s.p99 %f %d\n", prefix, name, ps[3], now)
+}
+
+// Emit is a no-op: GraphiteEmitter reads the registry directly via
+// AttachRegistry rather than receiving pre-serialized snapshots.
+func (this *GraphiteEmitter) Emit(bytes []byte) {}
+
+// ReportingInterval returns how often AttachRegistry flushes to Graphite.
+func (this *GraphiteEmitter) ReportingInterval() time.Duration {
+	return this.interval
+}
@@ -0,0 +1,98 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// HealthcheckServer is a HealthEmitter that keeps the most recently
+// evaluated set of HealthResults in memory, keyed by consumer, and exposes
+// them over HTTP on path, returning 200 when every check on every consumer
+// is healthy and 503 otherwise. This lets an orchestrator like Kubernetes
+// point a liveness or readiness probe straight at a running consumer
+// instead of relying on manual dashboard inspection. Keying by consumer
+// matters because several consumers can share one HealthcheckServer as
+// EmitterMetrics, each ticking independently - without it, each consumer's
+// tick would wipe out every other consumer's results instead of just its
+// own, silently hiding an unhealthy consumer behind a healthy one that
+// happened to tick more recently.
+type HealthcheckServer struct {
+	path string
+
+	lock    sync.RWMutex
+	results map[string][]HealthResult
+}
+
+// NewHealthcheckServer starts an HTTP server on addr and registers a
+// handler on path that reports every consumer's latest health check
+// results. A consumer's results are refreshed every time EmitHealth is
+// called with them, which happens once per that consumerMetrics' health
+// check evaluation tick.
+func NewHealthcheckServer(addr string, path string) *HealthcheckServer {
+	server := &HealthcheckServer{path: path, results: make(map[string][]HealthResult)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, server.serve)
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			panic(err)
+		}
+	}()
+
+	return server
+}
+
+// EmitHealth stores results, all from the same consumer, for the next
+// probe request to read.
+func (this *HealthcheckServer) EmitHealth(results []HealthResult) {
+	if len(results) == 0 {
+		return
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.results[results[0].Consumer] = results
+}
+
+func (this *HealthcheckServer) serve(w http.ResponseWriter, r *http.Request) {
+	this.lock.RLock()
+	all := make([]HealthResult, 0, len(this.results))
+	for _, results := range this.results {
+		all = append(all, results...)
+	}
+	this.lock.RUnlock()
+
+	healthy := true
+	for _, result := range all {
+		if !result.Healthy {
+			healthy = false
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(all)
+}
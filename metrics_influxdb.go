@@ -0,0 +1,193 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"bytes"
+	"fmt"
+	metrics "github.com/rcrowley/go-metrics"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var influxdbPercentiles = []float64{0.5, 0.75, 0.95, 0.99}
+
+// InfluxDBEmitter is a MetricsEmitter that background-flushes a metrics
+// registry straight to InfluxDB as line protocol points, instead of relying
+// on the default push-JSON-through-Emit model.
+type InfluxDBEmitter struct {
+	url      string
+	database string
+	username string
+	password string
+	interval time.Duration
+	tags     map[string]string
+
+	client *http.Client
+
+	lock            sync.RWMutex
+	resettingTimers []ResettingTimerSource
+}
+
+// NewInfluxDBEmitter creates an InfluxDBEmitter that, once attached to a
+// registry, POSTs a line-protocol batch to url every interval. tags are
+// applied to every point (e.g. host, cluster, consumer_group).
+func NewInfluxDBEmitter(url string, database string, username string, password string, interval time.Duration, tags map[string]string) *InfluxDBEmitter {
+	return &InfluxDBEmitter{
+		url:      url,
+		database: database,
+		username: username,
+		password: password,
+		interval: interval,
+		tags:     tags,
+		client:   &http.Client{Timeout: interval},
+	}
+}
+
+// AttachRegistry starts a goroutine that walks registry every interval and
+// writes its contents to InfluxDB. A failed write is logged and the loop
+// continues on the next tick rather than killing the consumer. The returned
+// io.Closer stops the goroutine; the owning consumerMetrics calls it from
+// Close() so the loop doesn't outlive the registry it reports from.
+func (this *InfluxDBEmitter) AttachRegistry(registry metrics.Registry) io.Closer {
+	ticker := time.NewTicker(this.interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := this.flush(registry); err != nil {
+					log.Printf("[InfluxDBEmitter] failed to write metrics to %s: %s", this.url, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return newTickerCloser(ticker, done)
+}
+
+// AttachResettingTimers wires in a consumer's resetting timers so flush can
+// report them alongside the registry's own metrics. An InfluxDBEmitter can
+// be shared by several consumers, so each call appends rather than
+// replacing.
+func (this *InfluxDBEmitter) AttachResettingTimers(source ResettingTimerSource) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.resettingTimers = append(this.resettingTimers, source)
+}
+
+func (this *InfluxDBEmitter) flush(registry metrics.Registry) error {
+	now := time.Now().UnixNano()
+	buffer := &bytes.Buffer{}
+
+	registry.Each(func(name string, i interface{}) {
+		tags := this.lineTags()
+		switch metric := i.(type) {
+		case metrics.Counter:
+			fmt.Fprintf(buffer, "%s%s count=%d %d\n", name, tags, metric.Count(), now)
+		case metrics.Gauge:
+			fmt.Fprintf(buffer, "%s%s value=%d %d\n", name, tags, metric.Value(), now)
+		case metrics.GaugeFloat64:
+			fmt.Fprintf(buffer, "%s%s value=%f %d\n", name, tags, metric.Value(), now)
+		case metrics.Meter:
+			m := metric.Snapshot()
+			fmt.Fprintf(buffer, "%s%s count=%d,rate1=%f,rate5=%f,rate15=%f %d\n", name, tags, m.Count(), m.Rate1(), m.Rate5(), m.Rate15(), now)
+		case metrics.Histogram:
+			h := metric.Snapshot()
+			ps := h.Percentiles(influxdbPercentiles)
+			fmt.Fprintf(buffer, "%s%s count=%d,min=%d,max=%d,mean=%f,p50=%f,p75=%f,p95=%f,p99=%f %d\n",
+				name, tags, h.Count(), h.Min(), h.Max(), h.Mean(), ps[0], ps[1], ps[2], ps[3], now)
+		case metrics.Timer:
+			t := metric.Snapshot()
+			ps := t.Percentiles(influxdbPercentiles)
+			fmt.Fprintf(buffer, "%s%s count=%d,min=%d,max=%d,mean=%f,p50=%f,p75=%f,p95=%f,p99=%f %d\n",
+				name, tags, t.Count(), t.Min(), t.Max(), t.Mean(), ps[0], ps[1], ps[2], ps[3], now)
+		}
+	})
+
+	this.flushResettingTimers(buffer, now)
+
+	if buffer.Len() == 0 {
+		return nil
+	}
+
+	request, err := http.NewRequest("POST", fmt.Sprintf("%s/write?db=%s", this.url, this.database), buffer)
+	if err != nil {
+		return err
+	}
+	if this.username != "" {
+		request.SetBasicAuth(this.username, this.password)
+	}
+
+	response, err := this.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("InfluxDB returned status %s", response.Status)
+	}
+
+	return nil
+}
+
+// flushResettingTimers writes every attached consumer's ResettingTimers,
+// since they live in a side-channel registry.Each never sees - see
+// ResettingTimer's doc comment for why.
+func (this *InfluxDBEmitter) flushResettingTimers(buffer *bytes.Buffer, now int64) {
+	this.lock.RLock()
+	sources := this.resettingTimers
+	this.lock.RUnlock()
+
+	for _, source := range sources {
+		source.Each(func(name string, timer *ResettingTimer) {
+			tags := this.lineTags()
+			s := timer.Snapshot(influxdbPercentiles...)
+			fmt.Fprintf(buffer, "%s%s count=%d,min=%d,max=%d,mean=%d,p50=%d,p75=%d,p95=%d,p99=%d %d\n",
+				name, tags, s.Count, s.Min, s.Max, s.Mean, s.Percentiles[0.5], s.Percentiles[0.75], s.Percentiles[0.95], s.Percentiles[0.99], now)
+		})
+	}
+}
+
+func (this *InfluxDBEmitter) lineTags() string {
+	if len(this.tags) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(this.tags))
+	for k, v := range this.tags {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	return "," + strings.Join(pairs, ",")
+}
+
+// Emit is a no-op: InfluxDBEmitter reads the registry directly via
+// AttachRegistry rather than receiving pre-serialized snapshots.
+func (this *InfluxDBEmitter) Emit(bytes []byte) {}
+
+// ReportingInterval returns how often AttachRegistry flushes to InfluxDB.
+func (this *InfluxDBEmitter) ReportingInterval() time.Duration {
+	return this.interval
+}
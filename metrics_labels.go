@@ -0,0 +1,65 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import "sync"
+
+// MetricLabels is a read-only, concurrency-safe lookup from a registered
+// metric name to the base metric kind (e.g. "FetchDuration") and label set
+// (consumer/topic/partition/client_id) it was registered with.
+// consumerMetrics builds one as it registers each metric, so label-aware
+// emitters (Prometheus) can look labels up directly instead of re-deriving
+// them by parsing the flattened, dash-joined metric name - which is
+// ambiguous for any consumer or topic name that itself contains a dash.
+type MetricLabels interface {
+	Lookup(name string) (base string, labels map[string]string, ok bool)
+}
+
+type metricLabelEntry struct {
+	base   string
+	labels map[string]string
+}
+
+// metricLabelRegistry is the consumerMetrics-owned implementation of
+// MetricLabels. It is written to as metrics are registered or evicted and
+// read from by attached emitters.
+type metricLabelRegistry struct {
+	lock    sync.RWMutex
+	entries map[string]metricLabelEntry
+}
+
+func newMetricLabelRegistry() *metricLabelRegistry {
+	return &metricLabelRegistry{entries: make(map[string]metricLabelEntry)}
+}
+
+func (this *metricLabelRegistry) set(name string, base string, labels map[string]string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.entries[name] = metricLabelEntry{base: base, labels: labels}
+}
+
+func (this *metricLabelRegistry) unset(name string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.entries, name)
+}
+
+func (this *metricLabelRegistry) Lookup(name string) (string, map[string]string, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	entry, ok := this.entries[name]
+	return entry.base, entry.labels, ok
+}
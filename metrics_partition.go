@@ -0,0 +1,295 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"container/list"
+	"fmt"
+	metrics "github.com/rcrowley/go-metrics"
+	"strconv"
+	"sync"
+)
+
+// TopicAndPartition identifies a single partition of a topic. It is the key
+// per-partition metrics are tracked and evicted by.
+type TopicAndPartition struct {
+	Topic     string
+	Partition int32
+}
+
+func (this TopicAndPartition) String() string {
+	return fmt.Sprintf("%s-%d", this.Topic, this.Partition)
+}
+
+// MetricsConfig controls whether and how finely go_kafka_client breaks
+// metrics down by topic and partition. Per-partition metrics are off by
+// default because a consumer subscribed to many high-partition-count topics
+// can otherwise register an unbounded number of series.
+type MetricsConfig struct {
+	// EnablePerPartitionMetrics turns on fetch/batch/lag/commit metrics
+	// broken down by TopicAndPartition, in addition to the existing
+	// per-consumer aggregates.
+	EnablePerPartitionMetrics bool
+
+	// TopicWhitelist, if non-empty, restricts per-partition metrics to
+	// just these topics.
+	TopicWhitelist []string
+
+	// TopicBlacklist excludes these topics from per-partition metrics even
+	// if they would otherwise be allowed.
+	TopicBlacklist []string
+
+	// MaxActivePartitions bounds how many TopicAndPartition series are kept
+	// registered at once. Once the bound is reached, the least recently
+	// touched partition is evicted from the registry to make room.
+	MaxActivePartitions int
+
+	// ClientID, if set, is attached as the client_id label on every metric
+	// this consumer registers (Prometheus only).
+	ClientID string
+
+	// UseResettingTimers switches FetchDuration, WMsBatchDuration and
+	// CommitDuration (consumer-wide and, if enabled, per-partition) from
+	// metrics.Timer's exponentially-decaying reservoir to a ResettingTimer,
+	// so a burst of slow fetches doesn't keep dragging a reported p99 up
+	// long after it has passed. These timers are not registered into the
+	// metrics.Registry, so only emitters that support ResettingTimerAttacher
+	// (Graphite, InfluxDB) report them; the JSON snapshot handed to Emit
+	// (and so Prometheus) and Stats() do not include them. Health checks
+	// read Max() from either kind.
+	UseResettingTimers bool
+}
+
+// NewMetricsConfig creates a MetricsConfig with per-partition metrics
+// disabled and a reasonable default cardinality bound for callers that
+// enable it.
+func NewMetricsConfig() *MetricsConfig {
+	return &MetricsConfig{
+		MaxActivePartitions: 1000,
+	}
+}
+
+func (this *MetricsConfig) topicAllowed(topic string) bool {
+	if len(this.TopicBlacklist) > 0 {
+		for _, blacklisted := range this.TopicBlacklist {
+			if blacklisted == topic {
+				return false
+			}
+		}
+	}
+
+	if len(this.TopicWhitelist) == 0 {
+		return true
+	}
+
+	for _, whitelisted := range this.TopicWhitelist {
+		if whitelisted == topic {
+			return true
+		}
+	}
+
+	return false
+}
+
+// partitionMetrics holds the set of metrics tracked for a single
+// TopicAndPartition.
+type partitionMetrics struct {
+	fetchDurationTimer      DurationTimer
+	wmsBatchDurationTimer   DurationTimer
+	activeWorkersCounter    metrics.Counter
+	pendingWMsTasksCounter  metrics.Counter
+	messagesConsumedCounter metrics.Counter
+	bytesConsumedCounter    metrics.Counter
+	offsetLagGauge          metrics.Gauge
+	commitDurationTimer     DurationTimer
+}
+
+// partitionMetricsRegistry is a bounded LRU of partitionMetrics, keyed by
+// TopicAndPartition. Touching an entry moves it to the front; once the
+// configured maximum is exceeded, the least recently touched entry is
+// evicted and unregistered from the underlying metrics.Registry.
+type partitionMetricsRegistry struct {
+	consumerName string
+	prefix       string
+	registry     metrics.Registry
+	config       *MetricsConfig
+	labels       *metricLabelRegistry
+	timers       *resettingTimerRegistry
+
+	lock    sync.Mutex
+	order   *list.List
+	entries map[TopicAndPartition]*list.Element
+}
+
+type partitionMetricsEntry struct {
+	tp      TopicAndPartition
+	metrics *partitionMetrics
+}
+
+func newPartitionMetricsRegistry(consumerName string, prefix string, registry metrics.Registry, config *MetricsConfig, labels *metricLabelRegistry, timers *resettingTimerRegistry) *partitionMetricsRegistry {
+	return &partitionMetricsRegistry{
+		consumerName: consumerName,
+		prefix:       prefix,
+		registry:     registry,
+		config:       config,
+		labels:       labels,
+		timers:       timers,
+		order:        list.New(),
+		entries:      make(map[TopicAndPartition]*list.Element),
+	}
+}
+
+// getOrCreate returns the partitionMetrics for tp, registering a fresh set
+// and evicting the least recently used partition if the configured bound
+// would otherwise be exceeded. It returns nil if per-partition metrics are
+// disabled or tp's topic is not allowed.
+func (this *partitionMetricsRegistry) getOrCreate(tp TopicAndPartition) *partitionMetrics {
+	if this.config == nil || !this.config.EnablePerPartitionMetrics || !this.config.topicAllowed(tp.Topic) {
+		return nil
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if element, exists := this.entries[tp]; exists {
+		this.order.MoveToFront(element)
+		return element.Value.(*partitionMetricsEntry).metrics
+	}
+
+	if this.config.MaxActivePartitions > 0 && this.order.Len() >= this.config.MaxActivePartitions {
+		this.evictOldest()
+	}
+
+	entry := &partitionMetricsEntry{tp: tp, metrics: this.register(tp)}
+	element := this.order.PushFront(entry)
+	this.entries[tp] = element
+
+	return entry.metrics
+}
+
+func (this *partitionMetricsRegistry) register(tp TopicAndPartition) *partitionMetrics {
+	if this.labels != nil {
+		labels := this.tpLabels(tp)
+		for _, base := range partitionMetricBases {
+			this.labels.set(this.metricName(base, tp), base, labels)
+		}
+	}
+
+	useResetting := this.config != nil && this.config.UseResettingTimers
+
+	return &partitionMetrics{
+		fetchDurationTimer:      newDurationTimer(useResetting, this.metricName("FetchDuration", tp), this.registry, this.timers),
+		wmsBatchDurationTimer:   newDurationTimer(useResetting, this.metricName("WMsBatchDuration", tp), this.registry, this.timers),
+		activeWorkersCounter:    metrics.NewRegisteredCounter(this.metricName("WMsActiveWorkers", tp), this.registry),
+		pendingWMsTasksCounter:  metrics.NewRegisteredCounter(this.metricName("WMsPendingTasks", tp), this.registry),
+		messagesConsumedCounter: metrics.NewRegisteredCounter(this.metricName("MessagesConsumed", tp), this.registry),
+		bytesConsumedCounter:    metrics.NewRegisteredCounter(this.metricName("BytesConsumed", tp), this.registry),
+		offsetLagGauge:          metrics.NewRegisteredGauge(this.metricName("OffsetLag", tp), this.registry),
+		commitDurationTimer:     newDurationTimer(useResetting, this.metricName("CommitDuration", tp), this.registry, this.timers),
+	}
+}
+
+// tpLabels returns the label set this registry's per-partition metrics are
+// registered with: consumer, topic, partition, and client_id when
+// configured.
+func (this *partitionMetricsRegistry) tpLabels(tp TopicAndPartition) map[string]string {
+	labels := map[string]string{
+		"consumer":  this.consumerName,
+		"topic":     tp.Topic,
+		"partition": strconv.Itoa(int(tp.Partition)),
+	}
+	if this.config != nil && this.config.ClientID != "" {
+		labels["client_id"] = this.config.ClientID
+	}
+	return labels
+}
+
+// metricName formats base into the registered metric name for tp, preserving
+// the existing "Base-consumerName-topic-partition" convention and
+// prepending this.prefix with a dot when one is configured.
+func (this *partitionMetricsRegistry) metricName(base string, tp TopicAndPartition) string {
+	name := fmt.Sprintf("%s-%s-%s-%d", base, this.consumerName, tp.Topic, tp.Partition)
+	if this.prefix != "" {
+		name = this.prefix + "." + name
+	}
+	return name
+}
+
+// maxOffsetLag returns the highest offset lag currently tracked across every
+// partition held in the registry, or 0 if per-partition metrics are
+// disabled or none have been touched yet.
+func (this *partitionMetricsRegistry) maxOffsetLag() int64 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	var max int64
+	for element := this.order.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*partitionMetricsEntry)
+		if lag := entry.metrics.offsetLagGauge.Value(); lag > max {
+			max = lag
+		}
+	}
+
+	return max
+}
+
+// partitionMetricBases lists the per-TopicAndPartition metric names
+// registered for every tracked partition.
+var partitionMetricBases = []string{
+	"FetchDuration", "WMsBatchDuration", "WMsActiveWorkers", "WMsPendingTasks",
+	"MessagesConsumed", "BytesConsumed", "OffsetLag", "CommitDuration",
+}
+
+// evictOldest unregisters the least recently touched partition's metrics
+// from the registry. The caller must hold this.lock.
+func (this *partitionMetricsRegistry) evictOldest() {
+	oldest := this.order.Back()
+	if oldest == nil {
+		return
+	}
+
+	this.unregister(oldest.Value.(*partitionMetricsEntry))
+	this.order.Remove(oldest)
+	delete(this.entries, oldest.Value.(*partitionMetricsEntry).tp)
+}
+
+func (this *partitionMetricsRegistry) unregister(entry *partitionMetricsEntry) {
+	for _, base := range partitionMetricBases {
+		name := this.metricName(base, entry.tp)
+		this.registry.Unregister(name)
+		if this.labels != nil {
+			this.labels.unset(name)
+		}
+		if this.timers != nil {
+			this.timers.unset(name)
+		}
+	}
+}
+
+// unregisterAll unregisters every tracked partition's metrics, e.g. when the
+// owning consumerMetrics is closed against a SharedEmitter-mode registry
+// that other consumers may still be using.
+func (this *partitionMetricsRegistry) unregisterAll() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for element := this.order.Front(); element != nil; element = element.Next() {
+		this.unregister(element.Value.(*partitionMetricsEntry))
+	}
+
+	this.order.Init()
+	this.entries = make(map[TopicAndPartition]*list.Element)
+}
@@ -0,0 +1,109 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	metrics "github.com/rcrowley/go-metrics"
+	"testing"
+)
+
+func newTestPartitionMetricsRegistry(config *MetricsConfig) *partitionMetricsRegistry {
+	labels := newMetricLabelRegistry()
+	timers := newResettingTimerRegistry()
+	return newPartitionMetricsRegistry("myConsumer", "", metrics.NewRegistry(), config, labels, timers)
+}
+
+// TestPartitionMetricsRegistryEvictsLeastRecentlyTouched verifies the LRU
+// bound: once MaxActivePartitions is reached, creating one more partition
+// evicts the least recently touched one, not an arbitrary one.
+func TestPartitionMetricsRegistryEvictsLeastRecentlyTouched(t *testing.T) {
+	config := NewMetricsConfig()
+	config.EnablePerPartitionMetrics = true
+	config.MaxActivePartitions = 2
+	registry := newTestPartitionMetricsRegistry(config)
+
+	tp0 := TopicAndPartition{Topic: "myTopic", Partition: 0}
+	tp1 := TopicAndPartition{Topic: "myTopic", Partition: 1}
+	tp2 := TopicAndPartition{Topic: "myTopic", Partition: 2}
+
+	registry.getOrCreate(tp0)
+	registry.getOrCreate(tp1)
+	// Touch tp0 again so tp1, not tp0, becomes the least recently touched.
+	registry.getOrCreate(tp0)
+	registry.getOrCreate(tp2)
+
+	if len(registry.entries) != 2 {
+		t.Fatalf("expected 2 active partitions, got %d", len(registry.entries))
+	}
+	if _, ok := registry.entries[tp1]; ok {
+		t.Fatal("expected tp1 (least recently touched) to have been evicted")
+	}
+	if _, ok := registry.entries[tp0]; !ok {
+		t.Fatal("expected tp0 (recently touched) to still be active")
+	}
+	if _, ok := registry.entries[tp2]; !ok {
+		t.Fatal("expected tp2 (just created) to be active")
+	}
+}
+
+// TestPartitionMetricsRegistryEvictionUnregisters verifies eviction actually
+// unregisters the evicted partition's metrics and labels, rather than just
+// dropping it from the LRU bookkeeping - otherwise the metric would keep
+// being reported even though the registry no longer tracks it.
+func TestPartitionMetricsRegistryEvictionUnregisters(t *testing.T) {
+	config := NewMetricsConfig()
+	config.EnablePerPartitionMetrics = true
+	config.MaxActivePartitions = 1
+	registry := newTestPartitionMetricsRegistry(config)
+
+	tp0 := TopicAndPartition{Topic: "myTopic", Partition: 0}
+	tp1 := TopicAndPartition{Topic: "myTopic", Partition: 1}
+
+	registry.getOrCreate(tp0)
+	name := registry.metricName("OffsetLag", tp0)
+	if _, _, ok := registry.labels.Lookup(name); !ok {
+		t.Fatal("expected tp0's labels to be registered")
+	}
+
+	registry.getOrCreate(tp1)
+
+	if registry.registry.Get(name) != nil {
+		t.Fatal("expected tp0's metric to be unregistered from the registry after eviction")
+	}
+	if _, _, ok := registry.labels.Lookup(name); ok {
+		t.Fatal("expected tp0's labels to be unset after eviction")
+	}
+}
+
+// TestPartitionMetricsRegistryRespectsTopicFilters verifies topicAllowed is
+// applied before a partition is tracked at all, so a blacklisted (or
+// non-whitelisted) topic never consumes a slot in the LRU bound.
+func TestPartitionMetricsRegistryRespectsTopicFilters(t *testing.T) {
+	config := NewMetricsConfig()
+	config.EnablePerPartitionMetrics = true
+	config.TopicWhitelist = []string{"allowed"}
+	registry := newTestPartitionMetricsRegistry(config)
+
+	if m := registry.getOrCreate(TopicAndPartition{Topic: "blocked", Partition: 0}); m != nil {
+		t.Fatal("expected a non-whitelisted topic to be skipped entirely")
+	}
+	if m := registry.getOrCreate(TopicAndPartition{Topic: "allowed", Partition: 0}); m == nil {
+		t.Fatal("expected a whitelisted topic to be tracked")
+	}
+	if len(registry.entries) != 1 {
+		t.Fatalf("expected only the whitelisted topic to consume a slot, got %d entries", len(registry.entries))
+	}
+}
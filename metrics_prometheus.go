@@ -0,0 +1,336 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const prometheusMetricPrefix = "go_kafka_client_"
+
+// PrometheusEmitter is a MetricsEmitter that keeps the most recently pushed
+// snapshot of a consumer's metrics registry in memory and exposes it to a
+// Prometheus server on a pull basis, instead of pushing it anywhere itself.
+// It also implements LabelAttacher: rather than reverse-parsing consumer,
+// topic, partition and client_id back out of the flattened, dash-joined
+// metric name (ambiguous the moment any of those contain a dash
+// themselves), it reads them from the MetricLabels map consumerMetrics
+// builds at registration time, where they're still distinct values.
+type PrometheusEmitter struct {
+	addr string
+	path string
+
+	reportingInterval time.Duration
+
+	lock     sync.RWMutex
+	snapshot map[string]map[string]float64
+	labels   []MetricLabels
+}
+
+// NewPrometheusEmitter starts an HTTP server on addr and registers a handler
+// on path that renders the latest metrics snapshot in Prometheus text
+// exposition format. The snapshot itself is refreshed every time Emit is
+// called, which happens once per consumer reporting tick.
+func NewPrometheusEmitter(addr string, path string) *PrometheusEmitter {
+	emitter := &PrometheusEmitter{
+		addr:              addr,
+		path:              path,
+		reportingInterval: 10 * time.Second,
+		snapshot:          make(map[string]map[string]float64),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, emitter.scrape)
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			panic(err)
+		}
+	}()
+
+	return emitter
+}
+
+// Emit decodes a JSON metrics snapshot, as produced by go-metrics'
+// WriteJSONOnce, and merges it into the snapshot served on the next
+// Prometheus scrape. This can't be a wholesale replace because several
+// consumers can share one PrometheusEmitter as EmitterMetrics, each ticking
+// and calling Emit independently with only its own registry's series -
+// replacing the whole snapshot would make every consumer but the one that
+// ticked most recently disappear from /metrics. But it can't be a pure
+// additive merge either: each call's payload is a complete report of one
+// source's currently-live series, so any name that source no longer reports
+// (e.g. a partition evicted by partitionMetricsRegistry) has to be dropped
+// here, not kept around at its last-known value forever. sourceOf groups
+// names by the consumer they belong to so only that consumer's stale entries
+// are pruned, leaving other consumers' series untouched. A closed consumer
+// no longer calls Emit at all; consumerMetrics.Close removes its series from
+// the snapshot directly.
+func (this *PrometheusEmitter) Emit(bytes []byte) {
+	snapshot := make(map[string]map[string]float64)
+	if err := json.Unmarshal(bytes, &snapshot); err != nil {
+		return
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	sources := make(map[string]bool, len(snapshot))
+	for name := range snapshot {
+		sources[this.sourceOf(name)] = true
+	}
+
+	for name := range this.snapshot {
+		if _, stillReported := snapshot[name]; stillReported {
+			continue
+		}
+		if sources[this.sourceOf(name)] {
+			delete(this.snapshot, name)
+		}
+	}
+
+	for name, fields := range snapshot {
+		this.snapshot[name] = fields
+	}
+}
+
+// sourceOf identifies which consumer a registered metric name belongs to, so
+// Emit can replace just that consumer's prior entries instead of merging
+// them forever with no eviction path. It prefers the attached MetricLabels
+// (exact, since consumerMetrics unsets them on partition eviction and
+// Close), falling back to splitMetricName's best-effort parse when no
+// attached source recognizes name.
+func (this *PrometheusEmitter) sourceOf(name string) string {
+	for _, source := range this.labels {
+		if _, labels, ok := source.Lookup(name); ok {
+			if consumer, ok := labels["consumer"]; ok {
+				return consumer
+			}
+		}
+	}
+
+	_, labels := splitMetricName(name)
+	return labels["consumer"]
+}
+
+// ReportingInterval is how often the owning consumerMetrics should refresh
+// the snapshot served to Prometheus.
+func (this *PrometheusEmitter) ReportingInterval() time.Duration {
+	return this.reportingInterval
+}
+
+// AttachLabels wires in the MetricLabels a consumerMetrics builds as it
+// registers metrics, so scrape can look up each metric's labels directly
+// instead of reverse-parsing its flattened name. A single PrometheusEmitter
+// can be shared by several consumers (e.g. all reporting through the
+// package-level EmitterMetrics, or several registered against one shared
+// registry), so each call appends rather than replacing - losing an
+// earlier consumer's labels would send scrape back to the ambiguous
+// splitMetricName fallback for its metrics.
+func (this *PrometheusEmitter) AttachLabels(labels MetricLabels) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.labels = append(this.labels, labels)
+}
+
+func (this *PrometheusEmitter) scrape(w http.ResponseWriter, r *http.Request) {
+	this.lock.RLock()
+	snapshot := this.snapshot
+	attached := this.labels
+	this.lock.RUnlock()
+
+	names := make([]string, 0, len(snapshot))
+	for name := range snapshot {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	written := make(map[string]bool)
+	for _, name := range names {
+		base, labels := this.resolveLabels(attached, name)
+		metric := prometheusMetricPrefix + camelToSnake(base)
+		writePrometheusSeries(w, metric, labels, snapshot[name], written)
+	}
+}
+
+// resolveLabels looks name up across attached, the MetricLabels each
+// consumerMetrics sharing this emitter populated at registration time (most
+// emitters only ever see one, but EmitterMetrics and shared registries can
+// fan several consumers into a single PrometheusEmitter). This is the
+// preferred path: it returns the consumer/topic/partition/client_id values
+// consumerMetrics already knows, rather than re-deriving them by splitting
+// name on "-", which is ambiguous the moment a consumer or topic name
+// contains a dash itself. Falling back to splitMetricName keeps scrape
+// working for callers that use PrometheusEmitter without going through
+// consumerMetrics.
+func (this *PrometheusEmitter) resolveLabels(attached []MetricLabels, name string) (string, map[string]string) {
+	for _, source := range attached {
+		if base, labels, ok := source.Lookup(name); ok {
+			return base, labels
+		}
+	}
+	return splitMetricName(name)
+}
+
+// splitMetricName takes a go-metrics name such as
+// "FetchDuration-myConsumer-myTopic-3" and separates the base metric name
+// from the consumer/topic/partition labels that are currently baked into it
+// as dash-separated suffixes.
+func splitMetricName(name string) (string, map[string]string) {
+	parts := strings.SplitN(name, "-", 2)
+	if len(parts) == 1 {
+		return parts[0], map[string]string{}
+	}
+
+	base := parts[0]
+	suffix := strings.Split(parts[1], "-")
+
+	labels := make(map[string]string)
+	switch len(suffix) {
+	case 1:
+		labels["consumer"] = suffix[0]
+	case 3:
+		labels["consumer"] = suffix[0]
+		labels["topic"] = suffix[1]
+		labels["partition"] = suffix[2]
+	default:
+		labels["consumer"] = strings.Join(suffix, "-")
+	}
+
+	return base, labels
+}
+
+// quantileFields maps the percentile keys go-metrics' registry JSON uses to
+// the Prometheus "quantile" label value they correspond to.
+var quantileFields = []struct {
+	field    string
+	quantile string
+}{
+	{"median", "0.5"},
+	{"75%", "0.75"},
+	{"95%", "0.95"},
+	{"99%", "0.99"},
+}
+
+func writePrometheusSeries(w http.ResponseWriter, metric string, labels map[string]string, fields map[string]float64, written map[string]bool) {
+	switch {
+	case len(fields) == 1 && has(fields, "count"):
+		writeHeader(w, written, metric, "counter")
+		fmt.Fprintf(w, "%s\n", prometheusSample(metric, labels, nil, fields["count"]))
+	case has(fields, "value"):
+		writeHeader(w, written, metric, "gauge")
+		fmt.Fprintf(w, "%s\n", prometheusSample(metric, labels, nil, fields["value"]))
+	case hasQuantiles(fields):
+		writeHeader(w, written, metric, "summary")
+		for _, q := range quantileFields {
+			if v, ok := fields[q.field]; ok {
+				fmt.Fprintf(w, "%s\n", prometheusSample(metric, labels, map[string]string{"quantile": q.quantile}, v))
+			}
+		}
+		count, hasCount := fields["count"]
+		if hasCount {
+			fmt.Fprintf(w, "%s\n", prometheusSample(metric+"_count", labels, nil, count))
+		}
+		if mean, ok := fields["mean"]; ok && hasCount {
+			fmt.Fprintf(w, "%s\n", prometheusSample(metric+"_sum", labels, nil, mean*count))
+		}
+		writeMeterRates(w, written, metric, labels, fields)
+	case has(fields, "1m.rate") || has(fields, "5m.rate") || has(fields, "15m.rate"):
+		writeHeader(w, written, metric, "counter")
+		if v, ok := fields["count"]; ok {
+			fmt.Fprintf(w, "%s\n", prometheusSample(metric, labels, nil, v))
+		}
+		writeMeterRates(w, written, metric, labels, fields)
+	}
+}
+
+func writeMeterRates(w http.ResponseWriter, written map[string]bool, metric string, labels map[string]string, fields map[string]float64) {
+	for _, rate := range []string{"1m", "5m", "15m"} {
+		if v, ok := fields[rate+".rate"]; ok {
+			rateMetric := metric + "_rate" + strings.TrimSuffix(rate, "m")
+			writeHeader(w, written, rateMetric, "gauge")
+			fmt.Fprintf(w, "%s\n", prometheusSample(rateMetric, labels, nil, v))
+		}
+	}
+}
+
+func hasQuantiles(fields map[string]float64) bool {
+	return has(fields, "median") || has(fields, "75%") || has(fields, "95%") || has(fields, "99%")
+}
+
+func has(fields map[string]float64, key string) bool {
+	_, ok := fields[key]
+	return ok
+}
+
+func writeHeader(w http.ResponseWriter, written map[string]bool, metric string, kind string) {
+	if written[metric] {
+		return
+	}
+	written[metric] = true
+	fmt.Fprintf(w, "# TYPE %s %s\n", metric, kind)
+}
+
+func prometheusSample(metric string, labels map[string]string, extra map[string]string, value float64) string {
+	all := make(map[string]string, len(labels)+len(extra))
+	for k, v := range labels {
+		all[k] = v
+	}
+	for k, v := range extra {
+		all[k] = v
+	}
+
+	if len(all) == 0 {
+		return fmt.Sprintf("%s %v", metric, value)
+	}
+
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, all[k]))
+	}
+
+	return fmt.Sprintf("%s{%s} %v", metric, strings.Join(pairs, ","), value)
+}
+
+// camelToSnake converts a go-metrics style CamelCase metric base name (e.g.
+// "FetchDuration") into the snake_case convention Prometheus metric names
+// use (e.g. "fetch_duration").
+func camelToSnake(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r - 'A' + 'a')
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
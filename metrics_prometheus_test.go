@@ -0,0 +1,89 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func newTestPrometheusEmitter() *PrometheusEmitter {
+	return &PrometheusEmitter{
+		reportingInterval: 0,
+		snapshot:          make(map[string]map[string]float64),
+	}
+}
+
+func mustJSON(t *testing.T, fields map[string]map[string]float64) []byte {
+	t.Helper()
+	bytes, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatalf("failed to marshal fixture: %s", err)
+	}
+	return bytes
+}
+
+// TestPrometheusEmitterEvictsStalePartitions reproduces the cardinality leak:
+// a consumer's tick drops a series (e.g. partitionMetricsRegistry evicted the
+// partition), and the next Emit for that same consumer must retire it from
+// the snapshot instead of serving its last-known value forever.
+func TestPrometheusEmitterEvictsStalePartitions(t *testing.T) {
+	emitter := newTestPrometheusEmitter()
+	labels := newMetricLabelRegistry()
+	labels.set("OffsetLag-myConsumer-myTopic-0", "OffsetLag", map[string]string{"consumer": "myConsumer"})
+	labels.set("OffsetLag-myConsumer-myTopic-1", "OffsetLag", map[string]string{"consumer": "myConsumer"})
+	emitter.AttachLabels(labels)
+
+	emitter.Emit(mustJSON(t, map[string]map[string]float64{
+		"OffsetLag-myConsumer-myTopic-0": {"value": 1},
+		"OffsetLag-myConsumer-myTopic-1": {"value": 2},
+	}))
+	if len(emitter.snapshot) != 2 {
+		t.Fatalf("expected 2 series after first tick, got %d", len(emitter.snapshot))
+	}
+
+	// Partition 1 gets evicted: the label is unset and the next tick no
+	// longer reports it.
+	labels.unset("OffsetLag-myConsumer-myTopic-1")
+	emitter.Emit(mustJSON(t, map[string]map[string]float64{
+		"OffsetLag-myConsumer-myTopic-0": {"value": 3},
+	}))
+
+	if _, ok := emitter.snapshot["OffsetLag-myConsumer-myTopic-1"]; ok {
+		t.Fatal("evicted partition's series should have been dropped from the snapshot")
+	}
+	if got := emitter.snapshot["OffsetLag-myConsumer-myTopic-0"]["value"]; got != 3 {
+		t.Fatalf("expected surviving partition's value to be refreshed to 3, got %v", got)
+	}
+}
+
+// TestPrometheusEmitterKeepsOtherConsumersAlive ensures the staleness
+// eviction is scoped per-consumer: one consumer's tick must never expire
+// another consumer's series just because this tick didn't mention them.
+func TestPrometheusEmitterKeepsOtherConsumersAlive(t *testing.T) {
+	emitter := newTestPrometheusEmitter()
+	labels := newMetricLabelRegistry()
+	labels.set("OffsetLag-consumerA", "OffsetLag", map[string]string{"consumer": "consumerA"})
+	labels.set("OffsetLag-consumerB", "OffsetLag", map[string]string{"consumer": "consumerB"})
+	emitter.AttachLabels(labels)
+
+	emitter.Emit(mustJSON(t, map[string]map[string]float64{"OffsetLag-consumerA": {"value": 1}}))
+	emitter.Emit(mustJSON(t, map[string]map[string]float64{"OffsetLag-consumerB": {"value": 2}}))
+
+	if len(emitter.snapshot) != 2 {
+		t.Fatalf("expected both consumers' series to survive, got %d entries", len(emitter.snapshot))
+	}
+}
@@ -0,0 +1,201 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	metrics "github.com/rcrowley/go-metrics"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DurationTimer is the subset of metrics.Timer that consumerMetrics' business
+// logic and health checks need: recording an observation and reading the
+// largest one seen. metrics.Timer and *ResettingTimer both satisfy it, so
+// MetricsConfig.UseResettingTimers can switch which reservoir strategy backs
+// a duration timer without its callers caring which one they got.
+type DurationTimer interface {
+	Update(time.Duration)
+	Max() int64
+}
+
+// ResettingTimerSnapshot is a point-in-time read of a ResettingTimer: the
+// durations observed since the previous Snapshot call.
+type ResettingTimerSnapshot struct {
+	Count       int
+	Min         time.Duration
+	Max         time.Duration
+	Mean        time.Duration
+	Percentiles map[float64]time.Duration
+}
+
+// ResettingTimer is a Timer variant whose reservoir is cleared on every
+// Snapshot instead of decaying samples exponentially forever. Reporters that
+// flush on a fixed interval (InfluxDB, Graphite) use it so that a burst of
+// slow fetches doesn't keep dragging p99 up long after it has passed.
+//
+// It deliberately isn't registered into a metrics.Registry:
+// StandardRegistry.register only retains a metric whose concrete type
+// satisfies one of go-metrics' own interfaces (Counter, Gauge, Timer, ...)
+// and silently drops anything else, so a *ResettingTimer handed to
+// Registry.Register is never actually stored. Instead, consumerMetrics
+// tracks it in a resettingTimerRegistry - the same side-channel pattern
+// metricLabelRegistry uses for Prometheus labels - which Graphite/InfluxDB
+// read directly via ResettingTimerSource.
+type ResettingTimer struct {
+	lock   sync.Mutex
+	values []time.Duration
+}
+
+// NewResettingTimer constructs a ResettingTimer with an empty reservoir.
+func NewResettingTimer() *ResettingTimer {
+	return &ResettingTimer{}
+}
+
+// Update records a single observed duration.
+func (this *ResettingTimer) Update(duration time.Duration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.values = append(this.values, duration)
+}
+
+// Max returns the largest duration observed since the last Snapshot, without
+// clearing the reservoir. Health checks poll this on their own ticker,
+// independent of the Snapshot cadence a Graphite/InfluxDB flush uses, so it
+// has to be non-destructive.
+func (this *ResettingTimer) Max() int64 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	var max time.Duration
+	for _, v := range this.values {
+		if v > max {
+			max = v
+		}
+	}
+	return int64(max)
+}
+
+// Snapshot returns the requested percentiles, along with count/min/max/mean,
+// over the durations recorded since the last Snapshot, then clears the
+// reservoir.
+func (this *ResettingTimer) Snapshot(percentiles ...float64) ResettingTimerSnapshot {
+	this.lock.Lock()
+	values := this.values
+	this.values = nil
+	this.lock.Unlock()
+
+	snapshot := ResettingTimerSnapshot{
+		Count:       len(values),
+		Percentiles: make(map[float64]time.Duration, len(percentiles)),
+	}
+	if len(values) == 0 {
+		return snapshot
+	}
+
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	var sum time.Duration
+	for _, v := range values {
+		sum += v
+	}
+
+	snapshot.Min = values[0]
+	snapshot.Max = values[len(values)-1]
+	snapshot.Mean = sum / time.Duration(len(values))
+
+	for _, p := range percentiles {
+		snapshot.Percentiles[p] = values[percentileIndex(len(values), p)]
+	}
+
+	return snapshot
+}
+
+func percentileIndex(n int, percentile float64) int {
+	index := int(percentile * float64(n))
+	if index >= n {
+		index = n - 1
+	}
+	if index < 0 {
+		index = 0
+	}
+	return index
+}
+
+// ResettingTimerSource lets a reporter read a consumer's ResettingTimers by
+// name, bypassing metrics.Registry the same way MetricLabels bypasses it for
+// labels.
+type ResettingTimerSource interface {
+	Each(f func(name string, timer *ResettingTimer))
+}
+
+// ResettingTimerAttacher is implemented by MetricsEmitters that read a
+// ResettingTimerSource directly - Graphite and InfluxDB, which already walk
+// a metrics.Registry on their own schedule - rather than relying on that
+// registry to hand a ResettingTimer back on iteration.
+type ResettingTimerAttacher interface {
+	AttachResettingTimers(source ResettingTimerSource)
+}
+
+// resettingTimerRegistry is the consumerMetrics-owned implementation of
+// ResettingTimerSource. It is written to as resetting timers are registered
+// or evicted and read from by attached emitters.
+type resettingTimerRegistry struct {
+	lock    sync.RWMutex
+	entries map[string]*ResettingTimer
+}
+
+func newResettingTimerRegistry() *resettingTimerRegistry {
+	return &resettingTimerRegistry{entries: make(map[string]*ResettingTimer)}
+}
+
+func (this *resettingTimerRegistry) set(name string, timer *ResettingTimer) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.entries[name] = timer
+}
+
+func (this *resettingTimerRegistry) unset(name string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.entries, name)
+}
+
+func (this *resettingTimerRegistry) Each(f func(name string, timer *ResettingTimer)) {
+	this.lock.RLock()
+	entries := make(map[string]*ResettingTimer, len(this.entries))
+	for name, timer := range this.entries {
+		entries[name] = timer
+	}
+	this.lock.RUnlock()
+
+	for name, timer := range entries {
+		f(name, timer)
+	}
+}
+
+// newDurationTimer returns a *ResettingTimer tracked in timers if
+// useResetting is set - since registering it into r would silently drop it,
+// see ResettingTimer's doc comment - otherwise a regular metrics.Timer
+// registered into r as before.
+func newDurationTimer(useResetting bool, name string, r metrics.Registry, timers *resettingTimerRegistry) DurationTimer {
+	if useResetting {
+		timer := NewResettingTimer()
+		timers.set(name, timer)
+		return timer
+	}
+	return metrics.NewRegisteredTimer(name, r)
+}
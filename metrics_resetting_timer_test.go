@@ -0,0 +1,115 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"testing"
+	"time"
+)
+
+// TestResettingTimerSnapshotResetsReservoir verifies the whole point of
+// ResettingTimer over metrics.Timer: a burst of slow observations is only
+// reflected in the percentiles until the next Snapshot, not forever.
+func TestResettingTimerSnapshotResetsReservoir(t *testing.T) {
+	timer := NewResettingTimer()
+	timer.Update(100 * time.Millisecond)
+	timer.Update(200 * time.Millisecond)
+	timer.Update(300 * time.Millisecond)
+
+	first := timer.Snapshot(0.5, 0.99)
+	if first.Count != 3 {
+		t.Fatalf("expected count 3, got %d", first.Count)
+	}
+	if first.Max != 300*time.Millisecond {
+		t.Fatalf("expected max 300ms, got %s", first.Max)
+	}
+
+	second := timer.Snapshot(0.5, 0.99)
+	if second.Count != 0 {
+		t.Fatalf("expected reservoir cleared after Snapshot, got count %d", second.Count)
+	}
+}
+
+// TestResettingTimerMaxIsNonDestructive verifies Max() can be polled by a
+// health check on its own ticker without disturbing the reservoir a
+// Graphite/InfluxDB flush loop later drains via Snapshot.
+func TestResettingTimerMaxIsNonDestructive(t *testing.T) {
+	timer := NewResettingTimer()
+	timer.Update(50 * time.Millisecond)
+	timer.Update(150 * time.Millisecond)
+
+	if max := timer.Max(); max != int64(150*time.Millisecond) {
+		t.Fatalf("expected max 150ms, got %d", max)
+	}
+	if max := timer.Max(); max != int64(150*time.Millisecond) {
+		t.Fatalf("expected second Max() call to see the same value, got %d", max)
+	}
+
+	snapshot := timer.Snapshot(0.5)
+	if snapshot.Count != 2 {
+		t.Fatalf("expected Snapshot to still see both observations, got count %d", snapshot.Count)
+	}
+}
+
+// TestResettingTimerRegistryUnset verifies the side-channel registry -
+// consumerMetrics' replacement for registering a ResettingTimer into
+// metrics.Registry, which silently drops it - forgets an entry once
+// unset, so a Graphite/InfluxDB flush loop stops reporting it.
+func TestResettingTimerRegistryUnset(t *testing.T) {
+	registry := newResettingTimerRegistry()
+	registry.set("FetchDuration-myConsumer", NewResettingTimer())
+
+	seen := map[string]bool{}
+	registry.Each(func(name string, timer *ResettingTimer) { seen[name] = true })
+	if !seen["FetchDuration-myConsumer"] {
+		t.Fatal("expected the registered timer to be visible via Each")
+	}
+
+	registry.unset("FetchDuration-myConsumer")
+
+	seen = map[string]bool{}
+	registry.Each(func(name string, timer *ResettingTimer) { seen[name] = true })
+	if seen["FetchDuration-myConsumer"] {
+		t.Fatal("expected the unset timer to no longer be visible via Each")
+	}
+}
+
+// TestConsumerMetricsUsesResettingTimersWhenConfigured verifies
+// MetricsConfig.UseResettingTimers actually switches the duration timers to
+// ResettingTimer and tracks them in the side-channel registry, rather than
+// handing them to metrics.Registry where they'd be silently dropped.
+func TestConsumerMetricsUsesResettingTimersWhenConfigured(t *testing.T) {
+	config := NewMetricsConfig()
+	config.UseResettingTimers = true
+
+	kafkaMetrics := newConsumerMetrics("myConsumer", "", nil, config)
+	defer kafkaMetrics.Close()
+
+	if _, ok := kafkaMetrics.fetchDurationTimer.(*ResettingTimer); !ok {
+		t.Fatalf("expected fetchDurationTimer to be a *ResettingTimer, got %T", kafkaMetrics.fetchDurationTimer)
+	}
+
+	seen := map[string]bool{}
+	kafkaMetrics.timers.Each(func(name string, timer *ResettingTimer) { seen[name] = true })
+	if !seen[kafkaMetrics.metricName("FetchDuration")] {
+		t.Fatal("expected FetchDuration to be tracked in the resetting timer registry")
+	}
+
+	kafkaMetrics.fetchDurationTimer.Update(10 * time.Millisecond)
+	if max := kafkaMetrics.fetchDurationTimer.Max(); max != int64(10*time.Millisecond) {
+		t.Fatalf("expected max 10ms, got %d", max)
+	}
+}